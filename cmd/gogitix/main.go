@@ -6,6 +6,8 @@ import (
 	"flag"
 	"fmt"
 	"io/ioutil"
+	"os"
+	"strconv"
 	"strings"
 	"text/template"
 
@@ -14,10 +16,13 @@ import (
 	"github.com/fatih/color"
 
 	"github.com/launchdarkly/gogitix/lib"
+	"github.com/launchdarkly/gogitix/lib/cache"
 )
 
 var debug = false
 var dryRun = false
+var noCache = false
+var cacheDir = ""
 
 var defaultFlow = `
 - parallel:
@@ -47,9 +52,22 @@ var DefaultPathSpec = []string{"*.go", ":(exclude)vendor/"}
 var pathSpec FlagSlice
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "cache" {
+		runCacheCommand(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "watch" {
+		runWatchCommand(os.Args[2:])
+		return
+	}
+
 	flag.BoolVar(&debug, "d", false, "debug")
 	flag.BoolVar(&dryRun, "n", false, "dry run")
+	flag.BoolVar(&noCache, "no-cache", false, "don't read from or write to the check result cache")
+	flag.StringVar(&cacheDir, "cache-dir", "", "directory for the check result cache (default: $XDG_CACHE_HOME/gogitix)")
 	useLndir := *flag.Bool("lndir", false, "Use go-lndir or lndir if available")
+	gitRevSpec := flag.String("rev-spec", "", "diff against this git revspec (e.g. origin/main, A..B) instead of the working tree")
+	staging := flag.Bool("staging", false, "run checks against a staged copy of the index instead of the working tree")
 	flag.Var(&pathSpec, "path-spec", fmt.Sprintf("git path spec (default: %v)", DefaultPathSpec))
 
 	if len(pathSpec) == 0 {
@@ -61,9 +79,14 @@ func main() {
 
 	lib.SetDebug(debug)
 
+	checkCache, cacheErr := openCache()
+	if cacheErr != nil {
+		lib.Failf(cacheErr.Error())
+	}
+
 	gitRoot := strings.TrimSpace(lib.MustRunCmd("git", "rev-parse", "--show-toplevel"))
 
-	ws, wsErr := lib.Start(gitRoot, pathSpec, useLndir)
+	ws, wsErr := lib.Start(gitRoot, pathSpec, useLndir, *gitRevSpec, *staging)
 	if wsErr != nil {
 		lib.Failf(wsErr.Error())
 	}
@@ -126,7 +149,7 @@ func main() {
 	color.Yellow("Running checks...")
 
 	errResult := make(chan error)
-	go lib.RunCheck(ws, lib.CommandExecutor{DryRun: dryRun}, parsedCheck, errResult)
+	go lib.RunCheck(ws, lib.CommandExecutor{DryRun: dryRun}, parsedCheck, checkCache, errResult)
 
 	for {
 		if err, ok := <-errResult; !ok {
@@ -147,3 +170,170 @@ func (p *FlagSlice) Set(s string) error {
 	*p = append(*p, s)
 	return nil
 }
+
+// openCache returns the check result cache to use for this run, or nil if
+// --no-cache was passed.
+func openCache() (*cache.Cache, error) {
+	if noCache {
+		return nil, nil
+	}
+
+	dir := cacheDir
+	if dir == "" {
+		var err error
+		dir, err = cache.DefaultDir()
+		if err != nil {
+			return nil, fmt.Errorf("determining cache directory: %w", err)
+		}
+	}
+
+	return cache.New(dir)
+}
+
+// runCacheCommand implements `gogitix cache <subcommand>`.
+func runCacheCommand(args []string) {
+	fs := flag.NewFlagSet("cache", flag.ExitOnError)
+	dir := fs.String("cache-dir", "", "directory for the check result cache (default: $XDG_CACHE_HOME/gogitix)")
+	maxSize := fs.String("max-size", "512MB", "maximum cache size to keep after pruning (e.g. 512MB, 2GB)")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 || fs.Arg(0) != "prune" {
+		lib.Failf(`Usage: gogitix cache prune [--cache-dir DIR] [--max-size SIZE]`)
+	}
+	// Flag parsing stops at "prune"; parse the flags that follow it too.
+	fs.Parse(fs.Args()[1:])
+
+	cacheDir := *dir
+	if cacheDir == "" {
+		var err error
+		cacheDir, err = cache.DefaultDir()
+		if err != nil {
+			lib.Failf("determining cache directory: %s", err.Error())
+		}
+	}
+
+	maxBytes, err := parseByteSize(*maxSize)
+	if err != nil {
+		lib.Failf("invalid --max-size %q: %s", *maxSize, err.Error())
+	}
+
+	c, err := cache.New(cacheDir)
+	if err != nil {
+		lib.Failf(err.Error())
+	}
+	if err := c.Prune(maxBytes); err != nil {
+		lib.Failf(err.Error())
+	}
+}
+
+// byteSizeUnits is checked longest-suffix-first: every unit ends in "B", so
+// checking "B" before "KB"/"MB"/"GB" would match first and leave a
+// trailing "M"/"K"/"G" that strconv.ParseInt can't parse.
+var byteSizeUnits = []struct {
+	suffix     string
+	multiplier int64
+}{
+	{"KB", 1 << 10},
+	{"MB", 1 << 20},
+	{"GB", 1 << 30},
+	{"B", 1},
+}
+
+// parseByteSize parses sizes like "512MB" or "2GB" into a byte count.
+func parseByteSize(s string) (int64, error) {
+	for _, unit := range byteSizeUnits {
+		if strings.HasSuffix(s, unit.suffix) {
+			n, err := strconv.ParseInt(strings.TrimSuffix(s, unit.suffix), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return n * unit.multiplier, nil
+		}
+	}
+	return strconv.ParseInt(s, 10, 64)
+}
+
+// runWatchCommand implements `gogitix watch`: it keeps the workspace open
+// and re-runs `go build`/`go vet` for each package touched by a batch of
+// filesystem changes, reusing the check result cache so untouched
+// packages return instantly.
+//
+// Unlike the default `gogitix` command, watch does not read a flow.yaml:
+// it always runs the fixed build+vet check below. A config path passed on
+// the command line is rejected rather than silently ignored.
+func runWatchCommand(args []string) {
+	fs := flag.NewFlagSet("watch", flag.ExitOnError)
+	fs.BoolVar(&noCache, "no-cache", false, "don't read from or write to the check result cache")
+	fs.StringVar(&cacheDir, "cache-dir", "", "directory for the check result cache (default: $XDG_CACHE_HOME/gogitix)")
+	fs.Var(&pathSpec, "path-spec", fmt.Sprintf("git path spec (default: %v)", DefaultPathSpec))
+	fs.Parse(args)
+
+	if fs.NArg() > 0 {
+		lib.Failf("gogitix watch does not support a flow config file (got %q); it always runs build+vet per package", fs.Arg(0))
+	}
+
+	if len(pathSpec) == 0 {
+		pathSpec = make([]string, len(DefaultPathSpec))
+		copy(pathSpec, DefaultPathSpec)
+	}
+
+	checkCache, err := openCache()
+	if err != nil {
+		lib.Failf(err.Error())
+	}
+
+	gitRoot := strings.TrimSpace(lib.MustRunCmd("git", "rev-parse", "--show-toplevel"))
+	ws, err := lib.Start(gitRoot, pathSpec, false, "", false)
+	if err != nil {
+		lib.Failf(err.Error())
+	}
+	defer ws.Close()
+
+	runner, err := lib.NewRunner(lib.CheckSpec{Runner: lib.RunnerHost})
+	if err != nil {
+		lib.Failf(err.Error())
+	}
+
+	color.Yellow("Watching %s for changes...", gitRoot)
+	if err := lib.Watch(ws, pathSpec, packageCheck(ws, runner, checkCache)); err != nil {
+		lib.Failf(err.Error())
+	}
+}
+
+// packageCheck returns a lib.PackageCheck that builds and vets pkg,
+// consulting checkCache first when it's non-nil.
+func packageCheck(ws lib.Workspace, runner lib.Runner, checkCache *cache.Cache) lib.PackageCheck {
+	return func(pkg string) (lib.CheckResult, error) {
+		spec := lib.CheckSpec{
+			Name:    pkg,
+			Command: fmt.Sprintf("go build %s && go vet %s", pkg, pkg),
+		}
+
+		var key string
+		if checkCache != nil {
+			// Hash the Go files of the package and of everything it
+			// transitively imports, not just its own files: a change to
+			// anything it imports should also invalidate this entry.
+			if inputFiles, err := lib.GoListDeps(ws.RootDir, []string{pkg}); err == nil {
+				if k, err := cache.Key(spec.Name, spec.Command, inputFiles, ws.RootDir); err == nil {
+					key = k
+					if entry, hit, err := checkCache.Lookup(key); err == nil && hit {
+						return lib.CheckResult{ExitCode: entry.ExitCode, Stdout: string(entry.Stdout), Stderr: string(entry.Stderr)}, nil
+					}
+				}
+			}
+		}
+
+		ctx, cancel := lib.ContextForCheck(spec)
+		defer cancel()
+
+		result, err := runner.Run(ctx, ws, spec)
+		if err != nil {
+			return result, err
+		}
+		if checkCache != nil && key != "" {
+			checkCache.Store(key, cache.Entry{ExitCode: result.ExitCode, Stdout: []byte(result.Stdout), Stderr: []byte(result.Stderr)})
+		}
+		return result, nil
+	}
+}
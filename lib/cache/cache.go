@@ -0,0 +1,153 @@
+// Package cache implements a content-addressable, on-disk cache of check
+// results, keyed by a hash of everything that can affect a check's outcome:
+// its name, its resolved command, the Go toolchain version, and the
+// contents of the files it reads. This lets gogitix skip re-running checks
+// whose inputs haven't changed, the same way build-graph tools like Turbo
+// skip unaffected tasks.
+package cache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+
+	"golang.org/x/mod/sumdb/dirhash"
+)
+
+// Entry is the recorded outcome of a single check invocation.
+type Entry struct {
+	ExitCode int    `json:"exitCode"`
+	Stdout   []byte `json:"stdout"`
+	Stderr   []byte `json:"stderr"`
+}
+
+// Cache stores Entry values on disk under Dir, one file per key.
+type Cache struct {
+	Dir string
+}
+
+// New returns a Cache rooted at dir, creating it if it doesn't exist.
+func New(dir string) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("creating cache dir %s: %w", dir, err)
+	}
+	return &Cache{Dir: dir}, nil
+}
+
+// DefaultDir returns $XDG_CACHE_HOME/gogitix, falling back to
+// $HOME/.cache/gogitix if XDG_CACHE_HOME is unset.
+func DefaultDir() (string, error) {
+	base := os.Getenv("XDG_CACHE_HOME")
+	if base == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("locating home directory: %w", err)
+		}
+		base = filepath.Join(home, ".cache")
+	}
+	return filepath.Join(base, "gogitix"), nil
+}
+
+// Key computes the content hash for a check invocation from its name, its
+// resolved command, the Go toolchain version, and the contents of every
+// file in inputFiles (given relative to root). Hashing file bytes rather
+// than mtimes, and always using forward slashes, keeps the result stable
+// across machines and OSes.
+func Key(checkName, command string, inputFiles []string, root string) (string, error) {
+	sorted := append([]string(nil), inputFiles...)
+	sort.Strings(sorted)
+
+	filesHash, err := dirhash.Hash1(sorted, func(name string) (io.ReadCloser, error) {
+		return os.Open(filepath.Join(root, filepath.FromSlash(name)))
+	})
+	if err != nil {
+		return "", fmt.Errorf("hashing input files: %w", err)
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "check:%s\ncommand:%s\ngo:%s\nfiles:%s\n", checkName, command, runtime.Version(), filesHash)
+	return "h1:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Lookup returns the cached Entry for key, if one exists.
+func (c *Cache) Lookup(key string) (*Entry, bool, error) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("reading cache entry %s: %w", key, err)
+	}
+
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, false, fmt.Errorf("decoding cache entry %s: %w", key, err)
+	}
+	return &entry, true, nil
+}
+
+// Store records entry under key, writing atomically so concurrent gogitix
+// invocations never observe a partial file.
+func (c *Cache) Store(key string, entry Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("encoding cache entry %s: %w", key, err)
+	}
+
+	tmp, err := ioutil.TempFile(c.Dir, "tmp-*")
+	if err != nil {
+		return fmt.Errorf("creating temp cache file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("writing cache entry %s: %w", key, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("closing cache entry %s: %w", key, err)
+	}
+	return os.Rename(tmp.Name(), c.path(key))
+}
+
+// Prune deletes entries oldest-stored first (by file modification time)
+// until the cache is at or under maxBytes. Lookup does not update an
+// entry's time, so how recently an entry was read does not affect the order.
+func (c *Cache) Prune(maxBytes int64) error {
+	entries, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return fmt.Errorf("listing cache dir %s: %w", c.Dir, err)
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].ModTime().Before(entries[j].ModTime())
+	})
+
+	var total int64
+	for _, e := range entries {
+		total += e.Size()
+	}
+
+	for _, e := range entries {
+		if total <= maxBytes {
+			break
+		}
+		if err := os.Remove(filepath.Join(c.Dir, e.Name())); err != nil {
+			return fmt.Errorf("removing cache entry %s: %w", e.Name(), err)
+		}
+		total -= e.Size()
+	}
+	return nil
+}
+
+func (c *Cache) path(key string) string {
+	return filepath.Join(c.Dir, strings.NewReplacer(":", "_", "/", "_").Replace(key))
+}
@@ -0,0 +1,281 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/launchdarkly/gogitix/lib/cache"
+)
+
+// Check is a single node in a parsed flow tree: either a sequence of
+// children run one after another, a group of children run concurrently, or
+// (when neither is set) a leaf CheckSpec to execute.
+type Check struct {
+	Name     string
+	Sequence []Check
+	Parallel []Check
+	Spec     CheckSpec
+}
+
+// Parser turns the YAML-decoded flow config into a Check tree.
+type Parser struct{}
+
+// NewParser returns a Parser for flow YAML.
+func NewParser() *Parser {
+	return &Parser{}
+}
+
+// Parse turns raw (a []interface{} of {parallel: [...]} / {run: {...}}
+// nodes, as produced by yaml.Unmarshal) into a Check tree. prefix becomes
+// the Name of the returned sequence and of the parallel groups inside it;
+// leaf checks keep the name given by their own "name" key.
+func (p *Parser) Parse(raw interface{}, prefix string) (Check, error) {
+	items, ok := raw.([]interface{})
+	if !ok {
+		return Check{}, fmt.Errorf("expected a list of checks, got %T", raw)
+	}
+
+	seq := Check{Name: prefix}
+	for _, item := range items {
+		node, ok := toStringMap(item)
+		if !ok {
+			return Check{}, fmt.Errorf("expected a map, got %T", item)
+		}
+
+		if parallelRaw, ok := node["parallel"]; ok {
+			child, err := p.Parse(parallelRaw, prefix)
+			if err != nil {
+				return Check{}, err
+			}
+			seq.Sequence = append(seq.Sequence, Check{Name: child.Name, Parallel: child.Sequence})
+			continue
+		}
+
+		runRaw, ok := node["run"]
+		if !ok {
+			return Check{}, fmt.Errorf(`expected a "parallel" or "run" key, got %v`, node)
+		}
+		spec, err := parseRunSpec(runRaw)
+		if err != nil {
+			return Check{}, err
+		}
+		seq.Sequence = append(seq.Sequence, Check{Name: spec.Name, Spec: spec})
+	}
+
+	return seq, nil
+}
+
+func parseRunSpec(raw interface{}) (CheckSpec, error) {
+	m, ok := toStringMap(raw)
+	if !ok {
+		return CheckSpec{}, fmt.Errorf(`expected a map for "run", got %T`, raw)
+	}
+
+	var spec CheckSpec
+	spec.Name, _ = m["name"].(string)
+	spec.Description, _ = m["description"].(string)
+	spec.Command, _ = m["command"].(string)
+	if v, ok := m["runner"].(string); ok {
+		spec.Runner = RunnerKind(v)
+	}
+	if v, ok := m["image"].(string); ok {
+		spec.Image = v
+	}
+	if v, ok := m["network"].(string); ok {
+		spec.Network = NetworkMode(v)
+	}
+	if v, ok := m["timeout"].(string); ok && v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return CheckSpec{}, fmt.Errorf("check %q: invalid timeout %q: %w", spec.Name, v, err)
+		}
+		spec.Timeout = d
+	}
+	if envRaw, ok := m["env"]; ok {
+		envMap, ok := toStringMap(envRaw)
+		if !ok {
+			return CheckSpec{}, fmt.Errorf("check %q: expected a map for \"env\"", spec.Name)
+		}
+		spec.Env = make(map[string]string, len(envMap))
+		for k, v := range envMap {
+			spec.Env[k] = fmt.Sprintf("%v", v)
+		}
+	}
+
+	if spec.Command == "" {
+		return CheckSpec{}, fmt.Errorf("check %q: missing command", spec.Name)
+	}
+	return spec, nil
+}
+
+// toStringMap normalizes both map[string]interface{} and the
+// map[interface{}]interface{} that gopkg.in/yaml.v2 produces for nested
+// maps into a single map[string]interface{}.
+func toStringMap(raw interface{}) (map[string]interface{}, bool) {
+	switch m := raw.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		out := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			out[fmt.Sprintf("%v", k)] = v
+		}
+		return out, true
+	default:
+		return nil, false
+	}
+}
+
+// CommandExecutor runs a leaf CheckSpec, honoring DryRun by printing the
+// command instead of running it.
+type CommandExecutor struct {
+	DryRun bool
+}
+
+// Execute runs spec against ws, bounding it by spec.Timeout if one is set.
+func (e CommandExecutor) Execute(ws Workspace, spec CheckSpec) (CheckResult, error) {
+	if e.DryRun {
+		fmt.Printf("[dry run] %s: %s\n", spec.Name, spec.Command)
+		return CheckResult{}, nil
+	}
+
+	runner, err := NewRunner(spec)
+	if err != nil {
+		return CheckResult{}, err
+	}
+
+	ctx, cancel := ContextForCheck(spec)
+	defer cancel()
+
+	return runner.Run(ctx, ws, spec)
+}
+
+// RunCheck executes check against ws, consulting checkCache for each leaf
+// so a command whose inputs haven't changed is replayed instead of rerun,
+// and reports one error per failed leaf on result before closing it.
+// checkCache may be nil, in which case every leaf always runs.
+func RunCheck(ws Workspace, executor CommandExecutor, check Check, checkCache *cache.Cache, result chan<- error) {
+	defer close(result)
+	runCheck(ws, executor, check, checkCache, result)
+}
+
+func runCheck(ws Workspace, executor CommandExecutor, check Check, checkCache *cache.Cache, result chan<- error) {
+	switch {
+	case len(check.Parallel) > 0:
+		var wg sync.WaitGroup
+		for _, child := range check.Parallel {
+			wg.Add(1)
+			go func(c Check) {
+				defer wg.Done()
+				runCheck(ws, executor, c, checkCache, result)
+			}(child)
+		}
+		wg.Wait()
+
+	case len(check.Sequence) > 0:
+		for _, child := range check.Sequence {
+			runCheck(ws, executor, child, checkCache, result)
+		}
+
+	default:
+		runLeaf(ws, executor, check.Spec, checkCache, result)
+	}
+}
+
+func runLeaf(ws Workspace, executor CommandExecutor, spec CheckSpec, checkCache *cache.Cache, result chan<- error) {
+	var key string
+	if checkCache != nil && !executor.DryRun {
+		if inputFiles, err := GoListDeps(ws.RootDir, ws.UpdatedPackages); err == nil {
+			if k, err := cache.Key(spec.Name, spec.Command, inputFiles, ws.RootDir); err == nil {
+				key = k
+				if entry, hit, err := checkCache.Lookup(key); err == nil && hit {
+					result <- resultToErr(spec.Name, CheckResult{ExitCode: entry.ExitCode, Stdout: string(entry.Stdout), Stderr: string(entry.Stderr)})
+					return
+				}
+			}
+		}
+	}
+
+	checkResult, err := executor.Execute(ws, spec)
+	if err != nil {
+		result <- fmt.Errorf("%s: %w", spec.Name, err)
+		return
+	}
+
+	if checkCache != nil && key != "" {
+		checkCache.Store(key, cache.Entry{ExitCode: checkResult.ExitCode, Stdout: []byte(checkResult.Stdout), Stderr: []byte(checkResult.Stderr)})
+	}
+
+	result <- resultToErr(spec.Name, checkResult)
+}
+
+func resultToErr(name string, r CheckResult) error {
+	if r.ExitCode == 0 {
+		return nil
+	}
+	return fmt.Errorf("%s failed:\n%s%s", name, r.Stdout, r.Stderr)
+}
+
+// GoListDeps returns, relative to root, every .go file (test files
+// included) and embedded file of pkgs and of every non-standard package
+// they transitively import, as discovered by `go list -json -deps`. This
+// is deliberately broader than each package's own files: a
+// change to anything a check's packages import should also invalidate the
+// cache key.
+func GoListDeps(root string, pkgs []string) ([]string, error) {
+	if len(pkgs) == 0 {
+		return nil, nil
+	}
+
+	cmd := exec.Command("go", append([]string{"list", "-json", "-deps"}, pkgs...)...)
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list -deps %v: %w", pkgs, err)
+	}
+
+	type listedPackage struct {
+		Dir          string
+		GoFiles      []string
+		TestGoFiles  []string
+		XTestGoFiles []string
+		EmbedFiles   []string
+		Standard     bool
+	}
+
+	var files []string
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var p listedPackage
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("decoding go list output: %w", err)
+		}
+		if p.Standard {
+			continue
+		}
+		// Include test and embed files, not just GoFiles: the default
+		// flow's "test compile" check builds these, and a cache key that
+		// ignores them would replay a stale result after editing a test.
+		for _, f := range concat(p.GoFiles, p.TestGoFiles, p.XTestGoFiles, p.EmbedFiles) {
+			rel, err := filepath.Rel(root, filepath.Join(p.Dir, f))
+			if err != nil {
+				continue
+			}
+			files = append(files, filepath.ToSlash(rel))
+		}
+	}
+	return files, nil
+}
+
+func concat(lists ...[]string) []string {
+	var all []string
+	for _, l := range lists {
+		all = append(all, l...)
+	}
+	return all
+}
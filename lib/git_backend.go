@@ -0,0 +1,415 @@
+package lib
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+)
+
+// FileStatus is a single entry from a name-status diff, using the same
+// status letters as `git diff --name-status` (A for added, D for deleted,
+// M for everything else).
+type FileStatus struct {
+	Status string
+	Path   string
+}
+
+// GitBackend abstracts the git operations gogitix needs so that they can be
+// satisfied by a pure-Go implementation instead of shelling out to a `git`
+// binary and a POSIX shell. This is what lets gogitix run in minimal
+// containers and on Windows.
+type GitBackend interface {
+	// DiffNameStatus computes a name-status diff equivalent to
+	// `git diff --name-status --diff-filter=<filter> <fromRev> -- <pathSpec>`.
+	// If cached is true, the index is compared against fromRev (HEAD, if
+	// fromRev is empty) instead of the working tree.
+	DiffNameStatus(fromRev string, cached bool, filter string, pathSpec []string) ([]FileStatus, error)
+
+	// LocallyChangedFiles returns paths where the working tree differs
+	// from the index, restricted to pathSpec.
+	LocallyChangedFiles(pathSpec []string) ([]string, error)
+
+	// MaterializeIndex writes every blob currently in the index into
+	// destDir, creating directories as needed, without touching the
+	// working tree.
+	MaterializeIndex(destDir string) error
+}
+
+// goGitBackend implements GitBackend on top of go-git. The repository is
+// opened once and reused for every operation.
+type goGitBackend struct {
+	repo *git.Repository
+	root string
+}
+
+// NewGoGitBackend opens the git repository rooted at gitRoot and returns a
+// GitBackend that never shells out to `git` or `sh`.
+func NewGoGitBackend(gitRoot string) (GitBackend, error) {
+	repo, err := git.PlainOpen(gitRoot)
+	if err != nil {
+		return nil, fmt.Errorf("opening repository at %s: %w", gitRoot, err)
+	}
+	return &goGitBackend{repo: repo, root: gitRoot}, nil
+}
+
+func (b *goGitBackend) DiffNameStatus(fromRev string, cached bool, filter string, pathSpec []string) ([]FileStatus, error) {
+	var fromHashes, toHashes map[string]plumbing.Hash
+
+	if left, right, threeDot, isRange := parseRangeRevspec(fromRev); isRange {
+		// A range revspec (git diff's A..B / A...B) diffs two trees
+		// directly; there's no index or worktree involved, so cached is
+		// irrelevant here.
+		var err error
+		fromHashes, toHashes, err = b.rangeBlobHashes(left, right, threeDot)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		fromHashes, err = b.revBlobHashes(fromRev)
+		if err != nil {
+			return nil, err
+		}
+
+		toHashes, err = b.indexBlobHashes()
+		if err != nil {
+			return nil, err
+		}
+		if !cached {
+			if err := b.overlayWorktreeHashes(toHashes); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	matchers := compileGitPathSpec(pathSpec)
+	seen := map[string]bool{}
+	var result []FileStatus
+	for p := range fromHashes {
+		seen[p] = true
+	}
+	for p := range toHashes {
+		seen[p] = true
+	}
+	for p := range seen {
+		if !matchers.match(p) {
+			continue
+		}
+		from, inFrom := fromHashes[p]
+		to, inTo := toHashes[p]
+		var status string
+		switch {
+		case !inFrom && inTo:
+			status = "A"
+		case inFrom && !inTo:
+			status = "D"
+		case from != to:
+			status = "M"
+		default:
+			continue
+		}
+		if !allowedByFilter(status, filter) {
+			continue
+		}
+		result = append(result, FileStatus{Status: status, Path: p})
+	}
+
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result, nil
+}
+
+func (b *goGitBackend) LocallyChangedFiles(pathSpec []string) ([]string, error) {
+	wt, err := b.repo.Worktree()
+	if err != nil {
+		return nil, fmt.Errorf("opening worktree: %w", err)
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return nil, fmt.Errorf("computing worktree status: %w", err)
+	}
+
+	matchers := compileGitPathSpec(pathSpec)
+	var files []string
+	for p, s := range status {
+		if s.Worktree == git.Unmodified {
+			continue
+		}
+		if !matchers.match(p) {
+			continue
+		}
+		files = append(files, p)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (b *goGitBackend) MaterializeIndex(destDir string) error {
+	idx, err := b.repo.Storer.Index()
+	if err != nil {
+		return fmt.Errorf("reading index: %w", err)
+	}
+
+	for _, entry := range idx.Entries {
+		blob, err := object.GetBlob(b.repo.Storer, entry.Hash)
+		if err != nil {
+			return fmt.Errorf("reading blob for %s: %w", entry.Name, err)
+		}
+		if err := writeBlobToFile(blob, filepath.Join(destDir, filepath.FromSlash(entry.Name))); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// revBlobHashes returns the path -> blob hash map for every file in rev
+// (HEAD if rev is empty).
+func (b *goGitBackend) revBlobHashes(rev string) (map[string]plumbing.Hash, error) {
+	commit, err := b.resolveCommit(rev)
+	if err != nil {
+		return nil, err
+	}
+	return b.commitBlobHashes(commit)
+}
+
+// resolveCommit resolves rev (HEAD if empty) to a commit. rev must be a
+// single revision, not a range.
+func (b *goGitBackend) resolveCommit(rev string) (*object.Commit, error) {
+	if rev == "" {
+		rev = "HEAD"
+	}
+	ref, err := b.repo.ResolveRevision(plumbing.Revision(rev))
+	if err != nil {
+		return nil, fmt.Errorf("resolving %s: %w", rev, err)
+	}
+	commit, err := b.repo.CommitObject(*ref)
+	if err != nil {
+		return nil, fmt.Errorf("loading commit %s: %w", rev, err)
+	}
+	return commit, nil
+}
+
+// commitBlobHashes returns the path -> blob hash map for every file in
+// commit's tree.
+func (b *goGitBackend) commitBlobHashes(commit *object.Commit) (map[string]plumbing.Hash, error) {
+	tree, err := commit.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("loading tree for %s: %w", commit.Hash, err)
+	}
+
+	hashes := map[string]plumbing.Hash{}
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("walking tree for %s: %w", commit.Hash, err)
+		}
+		if entry.Mode.IsFile() {
+			hashes[name] = entry.Hash
+		}
+	}
+	return hashes, nil
+}
+
+// parseRangeRevspec detects the "A..B" / "A...B" range forms that `git
+// diff` accepts (and that gogitix's revspec mode is supposed to support),
+// which repo.ResolveRevision cannot parse on its own. threeDot distinguishes
+// "A...B" (diff against the merge base of A and B) from "A..B" (diff A
+// directly against B).
+func parseRangeRevspec(rev string) (left, right string, threeDot, ok bool) {
+	if i := strings.Index(rev, "..."); i >= 0 {
+		return rev[:i], rev[i+3:], true, true
+	}
+	if i := strings.Index(rev, ".."); i >= 0 {
+		return rev[:i], rev[i+2:], false, true
+	}
+	return "", "", false, false
+}
+
+// rangeBlobHashes resolves a range revspec's two endpoints to the trees
+// `git diff left..right` (or, for "...", left...right) would compare.
+func (b *goGitBackend) rangeBlobHashes(left, right string, threeDot bool) (map[string]plumbing.Hash, map[string]plumbing.Hash, error) {
+	if left == "" {
+		left = "HEAD"
+	}
+	if right == "" {
+		right = "HEAD"
+	}
+
+	leftCommit, err := b.resolveCommit(left)
+	if err != nil {
+		return nil, nil, err
+	}
+	rightCommit, err := b.resolveCommit(right)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if threeDot {
+		bases, err := leftCommit.MergeBase(rightCommit)
+		if err != nil {
+			return nil, nil, fmt.Errorf("finding merge base of %s and %s: %w", left, right, err)
+		}
+		if len(bases) == 0 {
+			return nil, nil, fmt.Errorf("no merge base found between %s and %s", left, right)
+		}
+		leftCommit = bases[0]
+	}
+
+	fromHashes, err := b.commitBlobHashes(leftCommit)
+	if err != nil {
+		return nil, nil, err
+	}
+	toHashes, err := b.commitBlobHashes(rightCommit)
+	if err != nil {
+		return nil, nil, err
+	}
+	return fromHashes, toHashes, nil
+}
+
+// indexBlobHashes returns the path -> blob hash map for the current index.
+func (b *goGitBackend) indexBlobHashes() (map[string]plumbing.Hash, error) {
+	idx, err := b.repo.Storer.Index()
+	if err != nil {
+		return nil, fmt.Errorf("reading index: %w", err)
+	}
+	hashes := make(map[string]plumbing.Hash, len(idx.Entries))
+	for _, entry := range idx.Entries {
+		hashes[entry.Name] = entry.Hash
+	}
+	return hashes, nil
+}
+
+// overlayWorktreeHashes replaces the index hash of every file the worktree
+// has modified with a hash computed from the on-disk contents, and drops
+// every file the worktree has deleted, so that toHashes reflects the
+// working tree rather than the index. Untracked files are not added.
+func (b *goGitBackend) overlayWorktreeHashes(toHashes map[string]plumbing.Hash) error {
+	wt, err := b.repo.Worktree()
+	if err != nil {
+		return fmt.Errorf("opening worktree: %w", err)
+	}
+	status, err := wt.Status()
+	if err != nil {
+		return fmt.Errorf("computing worktree status: %w", err)
+	}
+
+	for p, s := range status {
+		if s.Worktree == git.Unmodified || s.Worktree == git.Untracked {
+			// git diff HEAD never lists untracked files; skip them so
+			// DiffNameStatus's worktree mode matches that behavior instead
+			// of reporting every new, unstaged .go file as added.
+			continue
+		}
+		if s.Worktree == git.Deleted {
+			delete(toHashes, p)
+			continue
+		}
+		content, err := ioutil.ReadFile(filepath.Join(b.root, filepath.FromSlash(p)))
+		if err != nil {
+			return fmt.Errorf("reading %s: %w", p, err)
+		}
+		toHashes[p] = plumbing.ComputeHash(plumbing.BlobObject, content)
+	}
+	return nil
+}
+
+func writeBlobToFile(blob *object.Blob, dest string) error {
+	if err := os.MkdirAll(filepath.Dir(dest), os.ModePerm); err != nil {
+		return fmt.Errorf("creating directory for %s: %w", dest, err)
+	}
+	r, err := blob.Reader()
+	if err != nil {
+		return fmt.Errorf("reading blob contents for %s: %w", dest, err)
+	}
+	defer r.Close()
+
+	// dest may already be a symlink or hardlink back into the real working
+	// tree (shadowCopy populates rootDir that way before we get here).
+	// os.Create would follow the link and overwrite the developer's actual
+	// source file, so unlink first and always write a fresh, independent
+	// file.
+	if err := os.Remove(dest); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing existing %s: %w", dest, err)
+	}
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return fmt.Errorf("creating %s: %w", dest, err)
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r); err != nil {
+		return fmt.Errorf("writing %s: %w", dest, err)
+	}
+	return nil
+}
+
+// pathMatcher is a minimal stand-in for git's pathspec matching: plain
+// globs and ":(exclude)" negation, which is all gogitix's callers ever pass.
+type pathMatcher struct {
+	includes []string
+	excludes []string
+}
+
+func compileGitPathSpec(pathSpec []string) pathMatcher {
+	const excludePrefix = ":(exclude)"
+	var m pathMatcher
+	for _, p := range pathSpec {
+		if len(p) > len(excludePrefix) && p[:len(excludePrefix)] == excludePrefix {
+			m.excludes = append(m.excludes, p[len(excludePrefix):])
+		} else {
+			m.includes = append(m.includes, p)
+		}
+	}
+	if len(m.includes) == 0 {
+		m.includes = []string{"*"}
+	}
+	return m
+}
+
+func (m pathMatcher) match(p string) bool {
+	for _, ex := range m.excludes {
+		if hasPathPrefix(p, ex) {
+			return false
+		}
+	}
+	for _, in := range m.includes {
+		if ok, _ := path.Match(in, p); ok {
+			return true
+		}
+		if ok, _ := path.Match(in, path.Base(p)); ok {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPathPrefix(p, prefix string) bool {
+	prefix = path.Clean(prefix)
+	return p == prefix || (len(p) > len(prefix) && p[:len(prefix)+1] == prefix+"/")
+}
+
+func allowedByFilter(status, filter string) bool {
+	if filter == "" {
+		return true
+	}
+	for _, c := range filter {
+		if string(c) == status {
+			return true
+		}
+	}
+	return false
+}
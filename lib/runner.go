@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// RunnerKind selects which Runner implementation executes a check.
+type RunnerKind string
+
+const (
+	RunnerHost   RunnerKind = "host"
+	RunnerDocker RunnerKind = "docker"
+	RunnerNsjail RunnerKind = "nsjail"
+)
+
+// NetworkMode controls what network access a sandboxed check gets.
+type NetworkMode string
+
+const (
+	NetworkNone NetworkMode = "none"
+	NetworkHost NetworkMode = "host"
+)
+
+// CheckSpec is the fully resolved configuration for a single `run:` entry
+// in the flow YAML, including the runner selection and sandboxing options
+// threaded through by NewParser().Parse.
+type CheckSpec struct {
+	Name        string
+	Description string
+	Command     string
+	Runner      RunnerKind
+	Env         map[string]string
+	Image       string
+	Network     NetworkMode
+	Timeout     time.Duration
+}
+
+// CheckResult is the outcome of running a CheckSpec.
+type CheckResult struct {
+	ExitCode int
+	Stdout   string
+	Stderr   string
+}
+
+// Runner executes a CheckSpec's command and reports its outcome. It's the
+// extension point for sandboxing checks instead of always trusting the
+// developer's local PATH, GOPATH, and GOFLAGS.
+type Runner interface {
+	Run(ctx context.Context, ws Workspace, spec CheckSpec) (CheckResult, error)
+}
+
+// ContextForCheck returns a context bounded by spec.Timeout, or a
+// context with no deadline if no timeout was set. Callers must
+// always invoke the returned cancel func.
+func ContextForCheck(spec CheckSpec) (context.Context, context.CancelFunc) {
+	if spec.Timeout <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), spec.Timeout)
+}
+
+// NewRunner returns the Runner implementation selected by spec.Runner,
+// defaulting to the host runner when unset.
+func NewRunner(spec CheckSpec) (Runner, error) {
+	switch spec.Runner {
+	case "", RunnerHost:
+		return HostRunner{}, nil
+	case RunnerDocker:
+		return DockerRunner{}, nil
+	case RunnerNsjail:
+		return newNsjailRunner()
+	default:
+		return nil, fmt.Errorf("check %q: unknown runner %q", spec.Name, spec.Runner)
+	}
+}
+
+// HostRunner runs checks directly with the host toolchain, inheriting the
+// caller's PATH, GOPATH, and GOFLAGS. This is gogitix's original behavior.
+type HostRunner struct{}
+
+func (HostRunner) Run(ctx context.Context, ws Workspace, spec CheckSpec) (CheckResult, error) {
+	cmd := exec.CommandContext(ctx, "sh", "-c", spec.Command)
+	cmd.Dir = ws.RootDir
+	cmd.Env = mergeEnv(os.Environ(), spec.Env)
+	return runCmd(cmd, spec.Name)
+}
+
+// DockerRunner runs a check inside a container built from spec.Image,
+// mounting the workspace read-only so a check can't mutate the developer's
+// tree and can only see what it's told to see.
+type DockerRunner struct{}
+
+func (DockerRunner) Run(ctx context.Context, ws Workspace, spec CheckSpec) (CheckResult, error) {
+	if spec.Image == "" {
+		return CheckResult{}, fmt.Errorf("check %q: runner: docker requires an image:", spec.Name)
+	}
+
+	args := []string{
+		"run", "--rm",
+		"-v", fmt.Sprintf("%s:/workspace:ro", ws.RootDir),
+		"-w", "/workspace",
+	}
+	if spec.Network != NetworkHost {
+		args = append(args, "--network", "none")
+	}
+	for k, v := range spec.Env {
+		args = append(args, "-e", fmt.Sprintf("%s=%s", k, v))
+	}
+	args = append(args, spec.Image, "sh", "-c", spec.Command)
+
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	return runCmd(cmd, spec.Name)
+}
+
+// runCmd runs cmd, capturing stdout/stderr into a CheckResult. A non-zero
+// exit is reported through CheckResult.ExitCode rather than as an error;
+// only a failure to start or communicate with the process is an error.
+func runCmd(cmd *exec.Cmd, checkName string) (CheckResult, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	result := CheckResult{}
+	err := cmd.Run()
+	result.Stdout = stdout.String()
+	result.Stderr = stderr.String()
+
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		result.ExitCode = exitErr.ExitCode()
+		return result, nil
+	}
+	if err != nil {
+		return result, fmt.Errorf("running check %q: %w", checkName, err)
+	}
+	return result, nil
+}
+
+func mergeEnv(base []string, overrides map[string]string) []string {
+	env := append([]string(nil), base...)
+	for k, v := range overrides {
+		env = append(env, fmt.Sprintf("%s=%s", k, v))
+	}
+	return env
+}
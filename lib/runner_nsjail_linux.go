@@ -0,0 +1,124 @@
+//go:build linux
+// +build linux
+
+package lib
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// nsjailRunner sandboxes a check using nsjail, falling back to bwrap if
+// nsjail isn't installed, binding only the workspace, a scratch GOCACHE,
+// GOROOT, and the host paths a shell and the Go toolchain need to run, so
+// the check can't read or write anything else on disk.
+type nsjailRunner struct {
+	bin     string
+	isBwrap bool
+}
+
+func newNsjailRunner() (Runner, error) {
+	if path, err := exec.LookPath("nsjail"); err == nil {
+		return nsjailRunner{bin: path}, nil
+	}
+	if path, err := exec.LookPath("bwrap"); err == nil {
+		return nsjailRunner{bin: path, isBwrap: true}, nil
+	}
+	return nil, fmt.Errorf("runner: nsjail requires nsjail or bwrap on PATH")
+}
+
+func (r nsjailRunner) Run(ctx context.Context, ws Workspace, spec CheckSpec) (CheckResult, error) {
+	scratchCache, err := ioutil.TempDir("", "gogitix-gocache")
+	if err != nil {
+		return CheckResult{}, fmt.Errorf("creating scratch GOCACHE: %w", err)
+	}
+	defer os.RemoveAll(scratchCache)
+
+	goroot, err := goEnvGoroot()
+	if err != nil {
+		return CheckResult{}, err
+	}
+	roBinds := append([]string{goroot}, hermeticRoBinds()...)
+
+	var args []string
+	if r.isBwrap {
+		args = r.bwrapArgs(ws, spec, scratchCache, roBinds)
+	} else {
+		args = r.nsjailArgs(ws, spec, scratchCache, roBinds)
+	}
+
+	cmd := exec.CommandContext(ctx, r.bin, args...)
+	cmd.Env = mergeEnv(append(os.Environ(), "GOCACHE="+scratchCache), spec.Env)
+	return runCmd(cmd, spec.Name)
+}
+
+// nsjailArgs builds nsjail's flags. nsjail isolates the network namespace
+// by default, so --disable_clone_newnet (which shares the host's) is only
+// needed for network: host.
+func (r nsjailRunner) nsjailArgs(ws Workspace, spec CheckSpec, scratchCache string, roBinds []string) []string {
+	args := []string{
+		"--bindmount_ro", fmt.Sprintf("%s:%s", ws.RootDir, ws.RootDir),
+		"--bindmount", fmt.Sprintf("%s:%s", scratchCache, scratchCache),
+		"--cwd", ws.RootDir,
+	}
+	for _, p := range roBinds {
+		args = append(args, "--bindmount_ro", fmt.Sprintf("%s:%s", p, p))
+	}
+	if spec.Network == NetworkHost {
+		args = append(args, "--disable_clone_newnet")
+	}
+	return append(args, "--", "sh", "-c", spec.Command)
+}
+
+// bwrapArgs builds bubblewrap's flags. Unlike nsjail's --bindmount_ro/
+// --bindmount/--cwd, bwrap expects --ro-bind/--bind/--chdir, starts from an
+// empty root filesystem (as nsjail does), and shares
+// the host's network namespace by default, so isolation (the opposite of
+// nsjail's default) needs an explicit --unshare-net for anything other than
+// network: host.
+func (r nsjailRunner) bwrapArgs(ws Workspace, spec CheckSpec, scratchCache string, roBinds []string) []string {
+	args := []string{
+		"--ro-bind", ws.RootDir, ws.RootDir,
+		"--bind", scratchCache, scratchCache,
+		"--proc", "/proc",
+		"--dev", "/dev",
+		"--chdir", ws.RootDir,
+	}
+	for _, p := range roBinds {
+		args = append(args, "--ro-bind", p, p)
+	}
+	if spec.Network != NetworkHost {
+		args = append(args, "--unshare-net")
+	}
+	return append(args, "--", "sh", "-c", spec.Command)
+}
+
+// goEnvGoroot returns the GOROOT of the `go` on PATH, which needs to be
+// bound into the sandbox so the check's own toolchain invocations work.
+func goEnvGoroot() (string, error) {
+	out, err := exec.Command("go", "env", "GOROOT").Output()
+	if err != nil {
+		return "", fmt.Errorf("determining GOROOT: %w", err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// hermeticRoBindCandidates are the host paths a POSIX shell and a typical
+// Go toolchain need to resolve their own binaries, interpreters, and
+// shared libraries (nsjail and bwrap otherwise start from an environment
+// too bare to exec anything).
+var hermeticRoBindCandidates = []string{"/usr", "/bin", "/sbin", "/lib", "/lib64", "/etc/ssl", "/etc/resolv.conf"}
+
+func hermeticRoBinds() []string {
+	var paths []string
+	for _, p := range hermeticRoBindCandidates {
+		if _, err := os.Stat(p); err == nil {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package lib
+
+import "fmt"
+
+// nsjailRunner is only available on Linux, where nsjail/bwrap exist.
+func newNsjailRunner() (Runner, error) {
+	return nil, fmt.Errorf("runner: nsjail is only supported on Linux")
+}
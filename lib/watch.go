@@ -0,0 +1,222 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/fatih/color"
+	"github.com/fsnotify/fsnotify"
+
+	"gopkg.in/launchdarkly/gogitix.v2/lib/utils"
+)
+
+// debounceWindow batches up the burst of fsnotify events a single save
+// typically produces (editors often write, chmod, and rename in quick
+// succession) before triggering a re-run.
+const debounceWindow = 300 * time.Millisecond
+
+// PackageCheck runs whatever checks apply to pkg and reports the outcome.
+// Watch calls it once per package whose transitive dependencies intersect
+// the files that changed since the last batch.
+type PackageCheck func(pkg string) (CheckResult, error)
+
+// Watch keeps ws open and, on every debounced batch of filesystem changes
+// under ws.GitDir, recomputes the updated packages and calls check for each
+// one, printing a compact per-package status table that updates in place.
+// It never returns unless the watcher itself fails or is closed. Callers
+// that want cache.Cache-backed skipping of unchanged packages should
+// build that into check itself.
+func Watch(ws Workspace, pathSpec []string, check PackageCheck) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("creating filesystem watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := addRecursive(watcher, ws.GitDir); err != nil {
+		return fmt.Errorf("watching %s: %w", ws.GitDir, err)
+	}
+
+	backend, err := NewGoGitBackend(ws.GitDir)
+	if err != nil {
+		return err
+	}
+
+	status := map[string]rune{}
+	for _, pkg := range ws.UpdatedPackages {
+		status[pkg] = '⋯'
+	}
+	printStatus(status)
+
+	dirty := false
+	// Directories with a file event in the current batch. Seeded with the
+	// workspace's initial changes so the first batch also checks the
+	// packages shown as pending at startup.
+	batchDirs := utils.StrMap(ws.UpdatedDirs)
+	timer := time.NewTimer(debounceWindow)
+	if !timer.Stop() {
+		<-timer.C
+	}
+
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if info, statErr := os.Stat(event.Name); statErr == nil && info.IsDir() {
+				// A newly created directory needs its own watch.
+				watcher.Add(event.Name)
+			}
+			if !isGoFile(event.Name) {
+				continue
+			}
+			if rel, relErr := filepath.Rel(ws.GitDir, event.Name); relErr == nil {
+				batchDirs[filepath.Dir(rel)] = true
+			}
+			dirty = true
+			timer.Reset(debounceWindow)
+
+		case watchErr, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			color.Red("watch error: %s", watchErr)
+
+		case <-timer.C:
+			if !dirty {
+				continue
+			}
+			dirty = false
+
+			var updatedDirs []string
+			for _, d := range getUpdatedDirs(backend, pathSpec, "", false) {
+				if batchDirs[d] {
+					updatedDirs = append(updatedDirs, d)
+				}
+			}
+			batchDirs = map[string]bool{}
+			updatedPackages := getUpdatedPackages(ws.RootPackage, updatedDirs)
+
+			impacted, err := impactedPackages(ws.RootDir, updatedPackages)
+			if err != nil {
+				color.Red("computing impacted packages: %s", err)
+			} else {
+				updatedPackages = impacted
+			}
+
+			for _, pkg := range updatedPackages {
+				status[pkg] = '⋯'
+			}
+			printStatus(status)
+
+			for _, pkg := range updatedPackages {
+				result, checkErr := check(pkg)
+				if checkErr != nil {
+					color.Red("checking %s: %s", pkg, checkErr)
+					status[pkg] = '✗'
+					continue
+				}
+				if result.ExitCode == 0 {
+					status[pkg] = '✓'
+				} else {
+					status[pkg] = '✗'
+				}
+			}
+			printStatus(status)
+		}
+	}
+}
+
+// addRecursive subscribes to every directory under root, skipping .git.
+// fsnotify's inotify and ReadDirectoryChangesW backends both watch
+// non-recursively, so new directories are picked up as they're created by
+// re-adding them from the event loop above; kqueue (macOS) falls back to
+// the same per-directory model.
+func addRecursive(watcher *fsnotify.Watcher, root string) error {
+	return filepath.Walk(root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return nil
+		}
+		if info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		return watcher.Add(p)
+	})
+}
+
+func isGoFile(name string) bool {
+	return filepath.Ext(name) == ".go"
+}
+
+// impactedPackages returns every package under root that directly changed
+// or transitively imports one that did, so an edit to an upstream package
+// also re-triggers its downstream consumers instead of only the packages
+// getUpdatedDirs found files directly under.
+func impactedPackages(root string, changed []string) ([]string, error) {
+	if len(changed) == 0 {
+		return nil, nil
+	}
+
+	cmd := exec.Command("go", "list", "-json", "./...")
+	cmd.Dir = root
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("go list ./...: %w", err)
+	}
+
+	type listedPackage struct {
+		ImportPath string
+		Deps       []string
+	}
+
+	changedSet := utils.StrMap(changed)
+	var impacted []string
+	dec := json.NewDecoder(bytes.NewReader(out))
+	for dec.More() {
+		var p listedPackage
+		if err := dec.Decode(&p); err != nil {
+			return nil, fmt.Errorf("decoding go list output: %w", err)
+		}
+		if changedSet[p.ImportPath] {
+			impacted = append(impacted, p.ImportPath)
+			continue
+		}
+		for _, d := range p.Deps {
+			if changedSet[d] {
+				impacted = append(impacted, p.ImportPath)
+				break
+			}
+		}
+	}
+	return impacted, nil
+}
+
+func printStatus(status map[string]rune) {
+	pkgs := make([]string, 0, len(status))
+	for p := range status {
+		pkgs = append(pkgs, p)
+	}
+	sort.Strings(pkgs)
+
+	fmt.Print("\033[2J\033[H")
+	for _, p := range pkgs {
+		switch status[p] {
+		case '✓':
+			color.Green("  ✓ %s", p)
+		case '✗':
+			color.Red("  ✗ %s", p)
+		default:
+			color.Yellow("  ⋯ %s", p)
+		}
+	}
+}
@@ -1,16 +1,18 @@
 package lib
 
 import (
-	"bufio"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/fatih/color"
+	ignore "github.com/sabhiram/go-gitignore"
 
 	"log"
 
@@ -21,6 +23,7 @@ type Workspace struct {
 	GitDir              string   // Original git directory
 	WorkDir             string   // Base of the temporary directory created with git index
 	RootDir             string   // Base directory of the top-level go package in the git index
+	RootPackage         string   // Import path of the top-level go package
 	UpdatedDirs         []string // Directories that have changed and still exist (sorted)
 	UpdatedTrees        []string // Top directories that have changed and still exist (sorted)
 	UpdatedFiles        []string // Files that have changed and still exist
@@ -32,7 +35,10 @@ type Workspace struct {
 func Start(gitRoot string, pathSpec []string, useLndir bool, gitRevSpec string, staging bool) (Workspace, error) {
 	workDir := gitRoot
 	rootDir := gitRoot
-	rootPackage := strings.TrimSpace(MustRunCmd("sh", "-c", fmt.Sprintf("cd %s && go list -e .", gitRoot)))
+	rootPackage, err := goListRootPackage(gitRoot)
+	if err != nil {
+		return Workspace{}, err
+	}
 
 	// If we need to make a copy for staging of a revspec
 	if gitRevSpec != "" || staging {
@@ -44,7 +50,7 @@ func Start(gitRoot string, pathSpec []string, useLndir bool, gitRevSpec string,
 
 		workDir, _ = filepath.EvalSymlinks(workDir)
 
-		if err := os.Setenv("GOPATH", strings.Join([]string{workDir, os.Getenv("GOPATH")}, ":")); err != nil {
+		if err := os.Setenv("GOPATH", strings.Join([]string{workDir, os.Getenv("GOPATH")}, string(os.PathListSeparator))); err != nil {
 			return Workspace{}, err
 		}
 
@@ -69,34 +75,39 @@ func Start(gitRoot string, pathSpec []string, useLndir bool, gitRevSpec string,
 		}
 	}()
 
+	backend, err := NewGoGitBackend(gitRoot)
+	if err != nil {
+		return Workspace{}, err
+	}
+
 	updatedFilesChan := make(chan []string, 1)
 	locallyChangedFilesChan := make(chan []string, 1)
 	updatedDirsChan := make(chan []string, 1)
 
 	go func() {
-		updatedFilesChan <- getUpdatedFiles(gitRoot, pathSpec, gitRevSpec, staging)
+		updatedFilesChan <- getUpdatedFiles(backend, pathSpec, gitRevSpec, staging)
 	}()
 
 	go func() {
-		locallyChangedFilesChan <- getLocallyChangedFiles(gitRoot, pathSpec)
+		locallyChangedFilesChan <- getLocallyChangedFiles(backend, pathSpec)
 	}()
 
 	go func() {
-		updatedDirsChan <- getUpdatedDirs(gitRoot, pathSpec, gitRevSpec, staging)
+		updatedDirsChan <- getUpdatedDirs(backend, pathSpec, gitRevSpec, staging)
 	}()
 
 	// Try to create a shadow copy instead of checking out all the files
 	lndir := ""
 	lndirArgs := []string{"-silent"}
 	if useLndir {
-		if _, err := RunCmd("which", "go-lndir"); err == nil {
-			lndir = "go-lndir"
+		if path, err := exec.LookPath("go-lndir"); err == nil {
+			lndir = path
 			lndirArgs = append(lndirArgs, "-gitignore")
-		} else if _, err := RunCmd("which", "lndir"); err == nil {
-			lndir = "lndir"
-		} else {
-			Failf("Unable to find go-lndir or lndir")
+		} else if path, err := exec.LookPath("lndir"); err == nil {
+			lndir = path
 		}
+		// Neither tool is available (which is always true on Windows): fall
+		// back to an in-process shadow copy below instead of failing.
 	}
 
 	// Check out revSpec to test if we've been given one
@@ -111,7 +122,7 @@ func Start(gitRoot string, pathSpec []string, useLndir bool, gitRevSpec string,
 		}
 		MustRunCmd("git", "-C", gitRoot, "--work-tree", rootDir, "checkout", mostRecentSha, "--", ".")
 	} else if staging {
-		if lndir != "" {
+		if useLndir {
 			absGitRoot, err := filepath.Abs(gitRoot)
 			if err != nil {
 				return Workspace{}, err
@@ -120,16 +131,21 @@ func Start(gitRoot string, pathSpec []string, useLndir bool, gitRevSpec string,
 				return Workspace{}, err
 			}
 			// Start with a copy of the current workspace
-			MustRunCmd(lndir, append(lndirArgs, absGitRoot, rootDir)...)
-
-			// Copy out any files that have local changes from the index
-			cmd := fmt.Sprintf("git ls-files --modified --deleted | git checkout-index --stdin -f --prefix %s/", rootDir)
-			MustRunCmd("sh", "-c", cmd)
+			if lndir != "" {
+				MustRunCmd(lndir, append(lndirArgs, absGitRoot, rootDir)...)
+			} else if err := shadowCopy(absGitRoot, rootDir); err != nil {
+				return Workspace{}, err
+			}
+		}
 
-			// Finally, copy out the files we want to test
-			MustRunCmd("git", "-C", gitRoot, "checkout-index", "-f", "--prefix", rootDir+"/")
-		} else {
-			MustRunCmd("git", "-C", gitRoot, "checkout-index", "-a", "--prefix", rootDir+"/")
+		if err := os.MkdirAll(rootDir, os.ModePerm); err != nil {
+			return Workspace{}, err
+		}
+		// Materialize the staged tree by iterating the index and writing
+		// blob contents directly, instead of shelling out to
+		// `git ls-files | git checkout-index --stdin`.
+		if err := backend.MaterializeIndex(rootDir); err != nil {
+			return Workspace{}, err
 		}
 	}
 
@@ -147,6 +163,7 @@ func Start(gitRoot string, pathSpec []string, useLndir bool, gitRevSpec string,
 		GitDir:              gitRoot,
 		WorkDir:             workDir,
 		RootDir:             rootDir,
+		RootPackage:         rootPackage,
 		UpdatedFiles:        utils.SortStrings(updatedFiles),
 		UpdatedDirs:         utils.SortStrings(updatedDirs),
 		UpdatedPackages:     utils.SortStrings(updatedPackages),
@@ -155,22 +172,24 @@ func Start(gitRoot string, pathSpec []string, useLndir bool, gitRevSpec string,
 		deleteOnClose:       gitRevSpec != "" || staging,
 	}, nil
 }
-func getLocallyChangedFiles(gitRoot string, pathSpec []string) []string {
-	return strings.Fields(MustRunCmd("git", append([]string{"-C", gitRoot, "diff", "--name-only", "--diff-filter=ACMR", "--"}, pathSpec...)...))
+func getLocallyChangedFiles(backend GitBackend, pathSpec []string) []string {
+	files, err := backend.LocallyChangedFiles(pathSpec)
+	if err != nil {
+		Failf("Unable to determine locally changed files: %s", err.Error())
+	}
+	return files
 }
 
-func getUpdatedFiles(gitRoot string, pathSpec []string, gitRevSpec string, staging bool) []string {
-	diffCmd := []string{"diff", "--name-only", "--diff-filter=ACMR"}
-	if gitRevSpec != "" {
-		diffCmd = append(diffCmd, gitRevSpec)
-	} else if staging {
-		diffCmd = append(diffCmd, "--cached")
-	} else {
-		diffCmd = append(diffCmd, "HEAD")
+func getUpdatedFiles(backend GitBackend, pathSpec []string, gitRevSpec string, staging bool) []string {
+	statuses, err := backend.DiffNameStatus(gitRevSpec, staging, "ACMR", pathSpec)
+	if err != nil {
+		Failf("Unable to diff changed files: %s", err.Error())
+	}
+	files := make([]string, len(statuses))
+	for i, s := range statuses {
+		files[i] = s.Path
 	}
-	diffCmd = append(diffCmd, "--")
-	diffCmd = append(diffCmd, pathSpec...)
-	return strings.Fields(MustRunCmd("git", append([]string{"-C", gitRoot}, diffCmd...)...))
+	return files
 }
 
 func (ws Workspace) Close() error {
@@ -200,22 +219,14 @@ func getUpdatedPackages(rootPackage string, updatedDirs []string) []string {
 	return utils.StrKeys(updatedPackages)
 }
 
-func getUpdatedDirs(gitRoot string, pathSpec []string, gitRevSpec string, staging bool) []string {
-	diffCmd := []string{"diff", "--name-status", "--diff-filter=ACDMR"}
-	if gitRevSpec != "" {
-		diffCmd = append(diffCmd, gitRevSpec)
-	} else if staging {
-		diffCmd = append(diffCmd, "--cached")
-	} else {
-		diffCmd = append(diffCmd, "HEAD")
-	}
-	diffCmd = append(diffCmd, "--")
-	diffCmd = append(diffCmd, pathSpec...)
-	fileStatus := MustRunCmd("git", append([]string{"-C", gitRoot}, diffCmd...)...)
-	scanner := bufio.NewScanner(strings.NewReader(fileStatus))
+func getUpdatedDirs(backend GitBackend, pathSpec []string, gitRevSpec string, staging bool) []string {
+	statuses, err := backend.DiffNameStatus(gitRevSpec, staging, "ACDMR", pathSpec)
+	if err != nil {
+		Failf("Unable to diff changed directories: %s", err.Error())
+	}
 	var allFiles []string
-	for scanner.Scan() {
-		allFiles = append(allFiles, strings.Fields(scanner.Text())[1:]...)
+	for _, s := range statuses {
+		allFiles = append(allFiles, s.Path)
 	}
 	updatedDirs := map[string]bool{}
 	for _, f := range allFiles {
@@ -231,3 +242,81 @@ func getUpdatedDirs(gitRoot string, pathSpec []string, gitRevSpec string, stagin
 
 	return existingDirs
 }
+
+// goListRootPackage returns the import path of the package rooted at
+// gitRoot, running `go list` with its working directory set to gitRoot
+// rather than shelling out to `sh -c "cd %s && ..."`, which requires a
+// POSIX shell that isn't available on Windows.
+func goListRootPackage(gitRoot string) (string, error) {
+	cmd := exec.Command("go", "list", "-e", ".")
+	cmd.Dir = gitRoot
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("running go list in %s: %w", gitRoot, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// shadowCopy materializes a read-only shadow of src into dst without
+// shelling out to lndir/go-lndir, so that --staging works even where
+// neither tool is installed (e.g. on Windows). Paths matched by src's
+// top-level .gitignore are skipped. Hardlinks are used where possible,
+// falling back to symlinks and finally to a plain copy.
+func shadowCopy(src, dst string) error {
+	matcher, _ := ignore.CompileIgnoreFile(filepath.Join(src, ".gitignore"))
+
+	return filepath.Walk(src, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if info.IsDir() && info.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if matcher != nil && matcher.MatchesPath(rel) {
+			if info.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		target := filepath.Join(dst, rel)
+		if info.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(target), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.Link(p, target); err == nil {
+			return nil
+		}
+		if err := os.Symlink(p, target); err == nil {
+			return nil
+		}
+		return copyFileContents(p, target)
+	})
+}
+
+func copyFileContents(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	_, err = io.Copy(out, in)
+	return err
+}